@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var buddhabrotPathRegex = regexp.MustCompile(`^/buddhabrot/(\d+)/(\d+)/(\d+)\.png$`)
+
+func extractBuddhabrotCoords(path string) (TileCoords, error) {
+	matches := buddhabrotPathRegex.FindStringSubmatch(path)
+	if len(matches) != 4 {
+		return TileCoords{}, fmt.Errorf("not enough matches, got %d", len(matches))
+	}
+
+	var coords TileCoords
+	var err error
+	coords.Z, err = strconv.Atoi(matches[1])
+	if err != nil {
+		return TileCoords{}, fmt.Errorf("extracting z: %v", err)
+	}
+	coords.X, err = strconv.Atoi(matches[2])
+	if err != nil {
+		return TileCoords{}, fmt.Errorf("extracting x: %v", err)
+	}
+	coords.Y, err = strconv.Atoi(matches[3])
+	if err != nil {
+		return TileCoords{}, fmt.Errorf("extracting y: %v", err)
+	}
+
+	max := 1 << uint(coords.Z)
+	if coords.X < 0 || coords.X >= max || coords.Y < 0 || coords.Y >= max {
+		return TileCoords{}, fmt.Errorf("invalid tile coordinates: %v", coords)
+	}
+
+	return coords, nil
+}
+
+// buddhabrotMaxIters are the three escape-time cutoffs whose orbit
+// density accumulators map onto the R, G and B channels respectively,
+// giving the classic "nebula" look.
+var buddhabrotMaxIters = [3]int{100, 1000, 10000}
+
+// defaultBuddhabrotSamples is the default total number of random c
+// points sampled per accumulator, spread across the worker pool in
+// chunks; overridable via -buddhabrot-samples. Kept modest because each
+// sample on the maxIter=10000 accumulator can cost up to 10000
+// iterations, all before the HTTP handler can respond.
+const defaultBuddhabrotSamples = 100_000
+
+// defaultBuddhabrotBudget bounds how long a single tile's sampling may
+// run before jobs stop drawing further samples, overridable via
+// -buddhabrot-budget, so a single request can't hold a render slot
+// indefinitely regardless of -buddhabrot-samples.
+const defaultBuddhabrotBudget = 2 * time.Second
+
+// buddhabrotChunk is the most samples a single worker job draws, so a
+// tile's sampling work is split finely enough to spread across the pool.
+const buddhabrotChunk = 20_000
+
+// buddhabrotBudgetCheckEvery is how many samples a job draws between
+// checks of the sampling deadline, balancing how late a job may finish
+// against the overhead of the check itself.
+const buddhabrotBudgetCheckEvery = 1024
+
+// buddhabrotMargin is the fraction of the tile's extent sampled beyond
+// each edge, so orbits that start in a neighbouring tile but pass
+// through this one still land in, and contribute to, this tile's
+// accumulator.
+const buddhabrotMargin = 0.25
+
+// buddhabrotGamma softens the normalized density before colorizing, so
+// rare, long-lived orbits don't get washed out by common short ones.
+const buddhabrotGamma = 2.2
+
+// buddhabrotAccumulator is a tile-local histogram of orbit visits,
+// enlarged by buddhabrotMargin on every side so that orbits crossing
+// the tile edge from a neighbour still fall within the grid. Worker
+// jobs never write to bins directly — each accumulates into its own
+// local grid and merges it in under mu, since several jobs share one
+// accumulator.
+type buddhabrotAccumulator struct {
+	bins   []float64
+	size   int    // bins per side, covering the tile plus margin
+	extent Extent // the enlarged region the bins cover
+
+	mu sync.Mutex
+}
+
+func newBuddhabrotAccumulator(tileExtent Extent, margin float64) *buddhabrotAccumulator {
+	const tileSize = 256
+	size := int(tileSize * (1 + 2*margin))
+	w := tileExtent.Max.X - tileExtent.Min.X
+	h := tileExtent.Max.Y - tileExtent.Min.Y
+	return &buddhabrotAccumulator{
+		bins: make([]float64, size*size),
+		size: size,
+		extent: Extent{
+			Min: Vector{tileExtent.Min.X - w*margin, tileExtent.Min.Y - h*margin},
+			Max: Vector{tileExtent.Max.X + w*margin, tileExtent.Max.Y + h*margin},
+		},
+	}
+}
+
+// newLocalBins allocates a scratch grid the same size as a.bins for a
+// single worker job to accumulate into before merging.
+func (a *buddhabrotAccumulator) newLocalBins() []float64 {
+	return make([]float64, len(a.bins))
+}
+
+// addLocal increments the bin containing z in a job-local scratch
+// grid, if z falls within the accumulator's enlarged extent.
+func (a *buddhabrotAccumulator) addLocal(local []float64, z Vector) {
+	w := a.extent.Max.X - a.extent.Min.X
+	h := a.extent.Max.Y - a.extent.Min.Y
+	x := int((z.X - a.extent.Min.X) / w * float64(a.size))
+	y := int((z.Y - a.extent.Min.Y) / h * float64(a.size))
+	if x < 0 || x >= a.size || y < 0 || y >= a.size {
+		return
+	}
+	local[y*a.size+x]++
+}
+
+// merge adds a job-local scratch grid's counts into the shared bins.
+func (a *buddhabrotAccumulator) merge(local []float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, v := range local {
+		a.bins[i] += v
+	}
+}
+
+func (a *buddhabrotAccumulator) max() float64 {
+	var m float64
+	for _, v := range a.bins {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+// at returns the gamma-corrected, normalized density at tile pixel
+// (x, y), offset into the enlarged accumulator grid.
+func (a *buddhabrotAccumulator) at(x, y int, maxValue float64) float64 {
+	const tileSize = 256
+	margin := (a.size - tileSize) / 2
+	bx, by := x+margin, y+margin
+	if bx < 0 || bx >= a.size || by < 0 || by >= a.size || maxValue == 0 {
+		return 0
+	}
+	return math.Pow(a.bins[by*a.size+bx]/maxValue, 1/buddhabrotGamma)
+}
+
+// sampleBuddhabrot draws a random c within extent and, if its orbit
+// escapes before maxIter, replays the orbit and records each
+// intermediate z into local. Orbits that never escape are discarded
+// before any recording happens, as the Buddhabrot algorithm requires.
+func sampleBuddhabrot(rng *rand.Rand, extent Extent, maxIter int, acc *buddhabrotAccumulator, local []float64) {
+	c := Vector{
+		extent.Min.X + rng.Float64()*(extent.Max.X-extent.Min.X),
+		extent.Min.Y + rng.Float64()*(extent.Max.Y-extent.Min.Y),
+	}
+
+	var z Vector
+	escaped := false
+	for i := 0; i < maxIter; i++ {
+		z = Vector{z.X*z.X - z.Y*z.Y + c.X, 2*z.X*z.Y + c.Y}
+		if z.X*z.X+z.Y*z.Y > 4 {
+			escaped = true
+			break
+		}
+	}
+	if !escaped {
+		return
+	}
+
+	z = Vector{}
+	for i := 0; i < maxIter; i++ {
+		z = Vector{z.X*z.X - z.Y*z.Y + c.X, 2*z.X*z.Y + c.Y}
+		if z.X*z.X+z.Y*z.Y > 4 {
+			return
+		}
+		acc.addLocal(local, z)
+	}
+}
+
+// renderBuddhabrot accumulates orbit densities for each of
+// buddhabrotMaxIters, in parallel on the renderer's shared worker pool,
+// and colorizes R/G/B from the three accumulators. Sampling stops at
+// samples per accumulator, or once budget has elapsed since rendering
+// started, whichever comes first.
+func renderBuddhabrot(renderer *Renderer, coords TileCoords, samples int, budget time.Duration) image.Image {
+	release := renderer.Acquire()
+	defer release()
+
+	const tileSize = 256
+	extent := tileExtent(coords)
+	deadline := time.Now().Add(budget)
+
+	accs := make([]*buddhabrotAccumulator, len(buddhabrotMaxIters))
+	var jobs []func()
+	for i, maxIter := range buddhabrotMaxIters {
+		maxIter := maxIter
+		acc := newBuddhabrotAccumulator(extent, buddhabrotMargin)
+		accs[i] = acc
+		for done := 0; done < samples; done += buddhabrotChunk {
+			seed := int64(coords.Z)<<40 ^ int64(coords.X)<<20 ^ int64(coords.Y) ^ int64(maxIter)<<8 ^ int64(done)
+			n := buddhabrotChunk
+			if samples-done < n {
+				n = samples - done
+			}
+			jobs = append(jobs, func() {
+				rng := rand.New(rand.NewSource(seed))
+				local := acc.newLocalBins()
+				for s := 0; s < n; s++ {
+					if s%buddhabrotBudgetCheckEvery == 0 && time.Now().After(deadline) {
+						break
+					}
+					sampleBuddhabrot(rng, acc.extent, maxIter, acc, local)
+				}
+				acc.merge(local)
+			})
+		}
+	}
+	renderer.RunAll(jobs)
+
+	maxes := make([]float64, len(accs))
+	for i, acc := range accs {
+		maxes[i] = acc.max()
+	}
+
+	tile := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			r := accs[0].at(x, y, maxes[0])
+			g := accs[1].at(x, y, maxes[1])
+			b := accs[2].at(x, y, maxes[2])
+			tile.SetRGBA(x, y, color.RGBA{uint8(r * 0xff), uint8(g * 0xff), uint8(b * 0xff), 0xff})
+		}
+	}
+	return tile
+}
+
+// buddhabrotServer serves /buddhabrot/{z}/{x}/{y}.png tiles, sharing
+// renderer's worker pool and in-flight semaphore with noise tiles.
+// samples and budget bound the CPU cost of a single tile render; see
+// -buddhabrot-samples and -buddhabrot-budget.
+func buddhabrotServer(renderer *Renderer, samples int, budget time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coords, err := extractBuddhabrotCoords(r.URL.Path)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		tile := renderBuddhabrot(renderer, coords, samples, budget)
+		if err := png.Encode(w, tile); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("internal server error: " + err.Error()))
+			return
+		}
+	})
+}
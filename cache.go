@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CacheKey identifies one rendered tile on disk and in the hot layer:
+// its palette, tile coordinates, and hillshade settings (the zero value
+// means hillshading is off).
+type CacheKey struct {
+	Palette   string
+	Z, X, Y   int
+	Hillshade HillshadeParams
+}
+
+func (k CacheKey) path(dir string) string {
+	sub := "flat"
+	if k.Hillshade != (HillshadeParams{}) {
+		sub = fmt.Sprintf("hillshade-%g-%g-%g", k.Hillshade.AzimuthDeg, k.Hillshade.AltitudeDeg, k.Hillshade.Strength)
+	}
+	return filepath.Join(dir, k.Palette, sub, strconv.Itoa(k.Z), strconv.Itoa(k.X), strconv.Itoa(k.Y)+".png")
+}
+
+func (k CacheKey) etag(modTime time.Time, size int64) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s/%d/%d/%d/%v-%d-%d", k.Palette, k.Z, k.X, k.Y, k.Hillshade, modTime.UnixNano(), size)
+	return `"` + fmt.Sprintf("%x", h.Sum(nil))[:16] + `"`
+}
+
+// sweepInterval is how often the background sweeper checks disk usage.
+const sweepInterval = 5 * time.Minute
+
+// TileCache wraps a Renderer with an on-disk PNG cache keyed on
+// CacheKey (palette, Z, X, Y and hillshade settings), fronted by an
+// in-memory LRU hot layer. It honours
+// If-None-Match / If-Modified-Since so a client that already holds a
+// tile gets a 304, and only calls through to the renderer on a miss.
+// This is what lets the tile server sit behind Leaflet without
+// re-rendering the whole visible viewport on every pan.
+type TileCache struct {
+	dir              string
+	renderer         *Renderer
+	palettes         *PaletteSet
+	hillshadeEnabled bool
+	hot              *lru
+	highWater        int64
+}
+
+// NewTileCache creates a cache storing encoded PNGs under dir, with an
+// in-memory hot layer capped at hotBytes, and a background sweeper that
+// evicts the oldest files once total disk usage crosses highWaterBytes.
+func NewTileCache(dir string, renderer *Renderer, palettes *PaletteSet, hillshadeEnabled bool, hotBytes, highWaterBytes int64) *TileCache {
+	c := &TileCache{
+		dir:              dir,
+		renderer:         renderer,
+		palettes:         palettes,
+		hillshadeEnabled: hillshadeEnabled,
+		hot:              newLRU(hotBytes),
+		highWater:        highWaterBytes,
+	}
+	go c.sweepLoop()
+	return c
+}
+
+func (c *TileCache) sweepLoop() {
+	for {
+		time.Sleep(sweepInterval)
+		if err := c.sweep(); err != nil {
+			log.Printf("cache sweep: %v", err)
+		}
+	}
+}
+
+// sweep walks dir and deletes the least-recently-modified files once
+// total size exceeds highWater.
+func (c *TileCache) sweep() error {
+	type fileInfo struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []fileInfo
+	var total int64
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		files = append(files, fileInfo{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if total <= c.highWater {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= c.highWater {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("cache sweep: removing %s: %v", f.path, err)
+			continue
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// ServeHTTP serves a tile from the hot layer, the disk cache, or by
+// rendering it fresh on a miss, honouring conditional GETs.
+func (c *TileCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	coords, err := extractTileCoords(r.URL.Path)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	palette := c.palettes.Get(r.URL.Query().Get("palette"))
+	var hillshade *HillshadeParams
+	var hillshadeKey HillshadeParams
+	if c.hillshadeEnabled {
+		p := ParseHillshadeParams(r.URL.Query())
+		hillshade = &p
+		hillshadeKey = p
+	}
+	key := CacheKey{Palette: palette.Name, Z: coords.Z, X: coords.X, Y: coords.Y, Hillshade: hillshadeKey}
+
+	if body, modTime, ok := c.hot.get(key); ok {
+		serveCachedPNG(w, r, key, body, modTime)
+		return
+	}
+
+	diskPath := key.path(c.dir)
+	if body, modTime, err := readCacheFile(diskPath); err == nil {
+		c.hot.put(key, body, modTime)
+		serveCachedPNG(w, r, key, body, modTime)
+		return
+	}
+
+	tile := c.renderer.RenderTile(coords, palette, hillshade)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tile); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("internal server error: " + err.Error()))
+		return
+	}
+	body := buf.Bytes()
+	modTime := time.Now()
+
+	if err := writeCacheFile(diskPath, body, modTime); err != nil {
+		log.Printf("writing cache file %s: %v", diskPath, err)
+	}
+	c.hot.put(key, body, modTime)
+	serveCachedPNG(w, r, key, body, modTime)
+}
+
+func readCacheFile(path string) ([]byte, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return body, info.ModTime(), nil
+}
+
+func writeCacheFile(path string, body []byte, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func serveCachedPNG(w http.ResponseWriter, r *http.Request, key CacheKey, body []byte, modTime time.Time) {
+	etag := key.etag(modTime, int64(len(body)))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if since := r.Header.Get("If-Modified-Since"); since != "" {
+		if t, err := http.ParseTime(since); err == nil && !modTime.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(body)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+var contoursPathRegex = regexp.MustCompile(`^/contours/(\d+)/(\d+)/(\d+)\.json$`)
+
+func extractContoursCoords(path string) (TileCoords, error) {
+	matches := contoursPathRegex.FindStringSubmatch(path)
+	if len(matches) != 4 {
+		return TileCoords{}, fmt.Errorf("not enough matches, got %d", len(matches))
+	}
+
+	var coords TileCoords
+	var err error
+	coords.Z, err = strconv.Atoi(matches[1])
+	if err != nil {
+		return TileCoords{}, fmt.Errorf("extracting z: %v", err)
+	}
+	coords.X, err = strconv.Atoi(matches[2])
+	if err != nil {
+		return TileCoords{}, fmt.Errorf("extracting x: %v", err)
+	}
+	coords.Y, err = strconv.Atoi(matches[3])
+	if err != nil {
+		return TileCoords{}, fmt.Errorf("extracting y: %v", err)
+	}
+
+	max := 1 << uint(coords.Z)
+	if coords.X < 0 || coords.X >= max || coords.Y < 0 || coords.Y >= max {
+		return TileCoords{}, fmt.Errorf("invalid tile coordinates: %v", coords)
+	}
+
+	return coords, nil
+}
+
+// contourLevels are the iso-levels marching squares traces, matching
+// the thresholds the default biome palette bands on.
+var contourLevels = []float64{-0.1, 0.2, 0.4, 0.6, 0.9}
+
+// contourGridSize is the number of grid cells per tile side that
+// marching squares runs over; one more row/column of samples than this
+// is taken to cover every cell's four corners.
+const contourGridSize = 256
+
+// gridPoint is one sample of the simplexTorus surface: its value and
+// its position in the pseudo-lon/lat space tileExtent works in.
+type gridPoint struct {
+	pos   Vector
+	value float64
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONLineString      `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// renderContours samples simplexTorus over coords' extent on a
+// (contourGridSize+1)^2 grid, in parallel on renderer's worker pool,
+// then runs marching squares at each of contourLevels to build a
+// GeoJSON FeatureCollection of LineString features.
+func renderContours(renderer *Renderer, coords TileCoords) *geoJSONFeatureCollection {
+	const n = contourGridSize
+	extent := tileExtent(coords)
+
+	grid := make([][]gridPoint, n+1)
+	for j := range grid {
+		grid[j] = make([]gridPoint, n+1)
+	}
+
+	var jobs []func()
+	for j := 0; j <= n; j++ {
+		j := j
+		jobs = append(jobs, func() {
+			for i := 0; i <= n; i++ {
+				pos := Vector{
+					extent.Min.X + (extent.Max.X-extent.Min.X)*float64(i)/n,
+					extent.Min.Y + (extent.Max.Y-extent.Min.Y)*float64(j)/n,
+				}
+				grid[j][i] = gridPoint{pos: pos, value: simplexTorus(pos, coords)}
+			}
+		})
+	}
+	renderer.RunAll(jobs)
+
+	fc := &geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, level := range contourLevels {
+		for _, line := range marchingSquares(grid, level) {
+			lineCoords := make([][2]float64, len(line))
+			for i, p := range line {
+				lineCoords[i] = [2]float64{p.X, p.Y}
+			}
+			fc.Features = append(fc.Features, geoJSONFeature{
+				Type:       "Feature",
+				Geometry:   geoJSONLineString{Type: "LineString", Coordinates: lineCoords},
+				Properties: map[string]interface{}{"level": level},
+			})
+		}
+	}
+	return fc
+}
+
+// marchingSquaresEdges maps a cell's corner-above-level bitmask
+// (bit0=topLeft, bit1=topRight, bit2=bottomRight, bit3=bottomLeft) to
+// the pairs of cell edges a contour line crosses. Edge indices are
+// 0=top, 1=right, 2=bottom, 3=left. Cases 5 and 10 are ambiguous
+// saddles; we resolve them consistently rather than by centre-sampling.
+var marchingSquaresEdges = map[int][][2]int{
+	1:  {{0, 3}},
+	2:  {{0, 1}},
+	3:  {{1, 3}},
+	4:  {{1, 2}},
+	5:  {{0, 3}, {1, 2}},
+	6:  {{0, 2}},
+	7:  {{2, 3}},
+	8:  {{2, 3}},
+	9:  {{0, 2}},
+	10: {{0, 1}, {2, 3}},
+	11: {{1, 2}},
+	12: {{1, 3}},
+	13: {{0, 1}},
+	14: {{0, 3}},
+}
+
+// marchingSquares traces every contour line at level across grid and
+// stitches the resulting segments into polylines.
+func marchingSquares(grid [][]gridPoint, level float64) [][]Vector {
+	var segments [][2]Vector
+	for j := 0; j < len(grid)-1; j++ {
+		for i := 0; i < len(grid[j])-1; i++ {
+			tl, tr := grid[j][i], grid[j][i+1]
+			bl, br := grid[j+1][i], grid[j+1][i+1]
+
+			idx := 0
+			if tl.value >= level {
+				idx |= 1
+			}
+			if tr.value >= level {
+				idx |= 2
+			}
+			if br.value >= level {
+				idx |= 4
+			}
+			if bl.value >= level {
+				idx |= 8
+			}
+
+			for _, pair := range marchingSquaresEdges[idx] {
+				segments = append(segments,
+					[2]Vector{
+						edgeCrossing(pair[0], tl, tr, br, bl, level),
+						edgeCrossing(pair[1], tl, tr, br, bl, level),
+					})
+			}
+		}
+	}
+	return stitchContourSegments(segments)
+}
+
+// edgeCrossing returns where the level iso-line crosses the given cell
+// edge (0=top, 1=right, 2=bottom, 3=left), linearly interpolating
+// between the edge's two corner samples.
+func edgeCrossing(edge int, tl, tr, br, bl gridPoint, level float64) Vector {
+	switch edge {
+	case 0:
+		return lerpGridPoint(tl, tr, level)
+	case 1:
+		return lerpGridPoint(tr, br, level)
+	case 2:
+		return lerpGridPoint(bl, br, level)
+	default:
+		return lerpGridPoint(tl, bl, level)
+	}
+}
+
+func lerpGridPoint(a, b gridPoint, level float64) Vector {
+	t := (level - a.value) / (b.value - a.value)
+	return Vector{a.pos.X + (b.pos.X-a.pos.X)*t, a.pos.Y + (b.pos.Y-a.pos.Y)*t}
+}
+
+// contourPointKey rounds a position to a stable key so that segments
+// computed from the same shared cell edge (by two neighbouring cells)
+// join up despite floating point noise.
+func contourPointKey(v Vector) Vector {
+	const precision = 1e9
+	return Vector{
+		X: float64(int64(v.X*precision)) / precision,
+		Y: float64(int64(v.Y*precision)) / precision,
+	}
+}
+
+// stitchContourSegments joins unordered line segments sharing an
+// endpoint into polylines, so each contour is emitted as a single
+// LineString rather than one Feature per grid cell.
+func stitchContourSegments(segments [][2]Vector) [][]Vector {
+	type endpoint struct {
+		segment int
+		isStart bool
+	}
+	byPoint := map[Vector][]endpoint{}
+	for i, seg := range segments {
+		a, b := contourPointKey(seg[0]), contourPointKey(seg[1])
+		byPoint[a] = append(byPoint[a], endpoint{i, true})
+		byPoint[b] = append(byPoint[b], endpoint{i, false})
+	}
+
+	used := make([]bool, len(segments))
+	take := func(p Vector) (int, bool, bool) {
+		for _, e := range byPoint[p] {
+			if !used[e.segment] {
+				return e.segment, e.isStart, true
+			}
+		}
+		return 0, false, false
+	}
+
+	var lines [][]Vector
+	for i, seg := range segments {
+		if used[i] {
+			continue
+		}
+		used[i] = true
+		line := []Vector{seg[0], seg[1]}
+
+		for {
+			last := contourPointKey(line[len(line)-1])
+			idx, isStart, ok := take(last)
+			if !ok {
+				break
+			}
+			used[idx] = true
+			if isStart {
+				line = append(line, segments[idx][1])
+			} else {
+				line = append(line, segments[idx][0])
+			}
+		}
+		for {
+			first := contourPointKey(line[0])
+			idx, isStart, ok := take(first)
+			if !ok {
+				break
+			}
+			used[idx] = true
+			if isStart {
+				line = append([]Vector{segments[idx][1]}, line...)
+			} else {
+				line = append([]Vector{segments[idx][0]}, line...)
+			}
+		}
+
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// contoursServer serves /contours/{z}/{x}/{y}.json vector tiles,
+// sharing renderer's worker pool with the noise and buddhabrot tiles.
+func contoursServer(renderer *Renderer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coords, err := extractContoursCoords(r.URL.Path)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fc := renderContours(renderer, coords)
+		w.Header().Set("Content-Type", "application/geo+json")
+		if err := json.NewEncoder(w).Encode(fc); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("internal server error: " + err.Error()))
+			return
+		}
+	})
+}
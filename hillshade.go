@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// HillshadeParams controls the synthetic sun lighting the noise
+// surface. Azimuth and altitude are in degrees; Strength of 0 leaves
+// the palette colour untouched and 1 applies full Lambertian shading.
+type HillshadeParams struct {
+	AzimuthDeg  float64
+	AltitudeDeg float64
+	Strength    float64
+}
+
+// DefaultHillshadeParams matches a classic northwest sun.
+func DefaultHillshadeParams() HillshadeParams {
+	return HillshadeParams{AzimuthDeg: 315, AltitudeDeg: 45, Strength: 0.6}
+}
+
+// ParseHillshadeParams reads az, alt and strength from query, falling
+// back to DefaultHillshadeParams for any that are absent or unparsable.
+func ParseHillshadeParams(query url.Values) HillshadeParams {
+	p := DefaultHillshadeParams()
+	if v, err := strconv.ParseFloat(query.Get("az"), 64); err == nil {
+		p.AzimuthDeg = v
+	}
+	if v, err := strconv.ParseFloat(query.Get("alt"), 64); err == nil {
+		p.AltitudeDeg = v
+	}
+	if v, err := strconv.ParseFloat(query.Get("strength"), 64); err == nil {
+		p.Strength = v
+	}
+	return p
+}
+
+// vector3 is a 3D vector used for surface normals and sun direction;
+// Vector stays 2D for everything in tile/noise space.
+type vector3 struct {
+	X, Y, Z float64
+}
+
+func (v vector3) dot(u vector3) float64 {
+	return v.X*u.X + v.Y*u.Y + v.Z*u.Z
+}
+
+func (v vector3) normalize() vector3 {
+	length := math.Sqrt(v.dot(v))
+	if length == 0 {
+		return vector3{0, 0, 1}
+	}
+	return vector3{v.X / length, v.Y / length, v.Z / length}
+}
+
+// sunVector returns the unit vector pointing toward the sun. Azimuth is
+// measured clockwise from north; tile-space Y grows southward.
+func (p HillshadeParams) sunVector() vector3 {
+	az := p.AzimuthDeg * math.Pi / 180
+	alt := p.AltitudeDeg * math.Pi / 180
+	return vector3{
+		X: math.Cos(alt) * math.Sin(az),
+		Y: -math.Cos(alt) * math.Cos(az),
+		Z: math.Sin(alt),
+	}
+}
+
+// surfaceNormalAt estimates the normal of the simplexTorus surface at c
+// using central differences one pixel step to either side, in the
+// torus embedding. step must be in the same units as Vector (tile-extent
+// space); passing one tile pixel's worth of extent keeps the gradient
+// estimate local to the feature size the palette bands already resolve
+// at, at every zoom level.
+func surfaceNormalAt(c Vector, coords TileCoords, step float64) vector3 {
+	dx := (simplexTorus(Vector{c.X + step, c.Y}, coords) - simplexTorus(Vector{c.X - step, c.Y}, coords)) / (2 * step)
+	dy := (simplexTorus(Vector{c.X, c.Y + step}, coords) - simplexTorus(Vector{c.X, c.Y - step}, coords)) / (2 * step)
+	return vector3{-dx, -dy, 1}.normalize()
+}
+
+// shade returns the Lambertian shading factor for normal, clamped to
+// [0, 1] and blended toward 1 by (1 - Strength) so Strength=0 is a
+// no-op multiplier.
+func (p HillshadeParams) shade(normal vector3) float64 {
+	light := normal.dot(p.sunVector())
+	if light < 0 {
+		light = 0
+	}
+	return (1 - p.Strength) + p.Strength*light
+}
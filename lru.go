@@ -0,0 +1,70 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruEntry is one cached tile body held in memory by an lru.
+type lruEntry struct {
+	key     CacheKey
+	body    []byte
+	modTime time.Time
+}
+
+// lru is an in-memory hot layer for cached tile bodies, bounded by
+// total byte size rather than entry count, since tile PNGs vary widely
+// in size depending on how much detail they contain.
+type lru struct {
+	mu       sync.Mutex
+	budget   int64
+	size     int64
+	order    *list.List
+	elements map[CacheKey]*list.Element
+}
+
+// newLRU creates an lru that evicts its least-recently-used entries
+// once the total size of cached bodies exceeds budget bytes.
+func newLRU(budget int64) *lru {
+	return &lru{
+		budget:   budget,
+		order:    list.New(),
+		elements: map[CacheKey]*list.Element{},
+	}
+}
+
+func (l *lru) get(key CacheKey) ([]byte, time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.elements[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	l.order.MoveToFront(el)
+	e := el.Value.(*lruEntry)
+	return e.body, e.modTime, true
+}
+
+func (l *lru) put(key CacheKey, body []byte, modTime time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.elements[key]; ok {
+		l.size -= int64(len(el.Value.(*lruEntry).body))
+		l.order.Remove(el)
+		delete(l.elements, key)
+	}
+
+	el := l.order.PushFront(&lruEntry{key, body, modTime})
+	l.elements[key] = el
+	l.size += int64(len(body))
+
+	for l.size > l.budget && l.order.Len() > 0 {
+		oldest := l.order.Back()
+		e := oldest.Value.(*lruEntry)
+		l.order.Remove(oldest)
+		delete(l.elements, e.key)
+		l.size -= int64(len(e.body))
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"image"
 	"image/color"
 	"image/png"
 	"log"
@@ -15,11 +14,42 @@ import (
 
 func main() {
 	listenAddr := flag.String("listen-addr", ":8080", "address to listen for tile requests on")
+	colorsPath := flag.String("colors", "", "file or directory of palette definitions to load (see Load); defaults to the built-in biome palette")
+	workers := flag.Int("workers", 8, "number of worker goroutines rendering tile row-bands")
+	maxInFlight := flag.Int("max-inflight", 64, "maximum number of tile renders allowed to run concurrently")
+	cacheDir := flag.String("cache-dir", "", "directory to cache rendered tile PNGs under; disabled if empty")
+	cacheHotBytes := flag.Int64("cache-hot-bytes", 64<<20, "byte budget for the in-memory tile cache hot layer")
+	cacheHighWaterBytes := flag.Int64("cache-highwater-bytes", 1<<30, "total disk usage under -cache-dir that triggers eviction")
+	hillshadeEnabled := flag.Bool("hillshade", false, "shade tiles using a Lambertian relief derived from the noise gradient (see ?az=&alt=&strength=)")
+	buddhabrotSamples := flag.Int("buddhabrot-samples", defaultBuddhabrotSamples, "random c points sampled per buddhabrot accumulator per tile render")
+	buddhabrotBudget := flag.Duration("buddhabrot-budget", defaultBuddhabrotBudget, "maximum time a single buddhabrot tile render may spend sampling")
 	flag.Parse()
-	log.Fatal(http.ListenAndServe(*listenAddr, tileServer()))
+
+	palettes := &PaletteSet{Default: "default", Palettes: map[string]*Palette{"default": defaultPalette()}}
+	if *colorsPath != "" {
+		loaded, err := LoadPaletteSet(*colorsPath)
+		if err != nil {
+			log.Fatalf("loading -colors %s: %v", *colorsPath, err)
+		}
+		palettes = loaded
+	}
+
+	renderer := NewRenderer(*workers, *maxInFlight)
+
+	var tiles http.Handler = tileServer(renderer, palettes, *hillshadeEnabled)
+	if *cacheDir != "" {
+		tiles = NewTileCache(*cacheDir, renderer, palettes, *hillshadeEnabled, *cacheHotBytes, *cacheHighWaterBytes)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", tiles)
+	mux.Handle("/buddhabrot/", buddhabrotServer(renderer, *buddhabrotSamples, *buddhabrotBudget))
+	mux.Handle("/contours/", contoursServer(renderer))
+	mux.Handle("/metrics", renderer)
+	log.Fatal(http.ListenAndServe(*listenAddr, mux))
 }
 
-func tileServer() http.Handler {
+func tileServer(renderer *Renderer, palettes *PaletteSet, hillshadeEnabled bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		coords, err := extractTileCoords(r.URL.Path)
 		if err != nil {
@@ -27,7 +57,13 @@ func tileServer() http.Handler {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		tile := renderTile(coords)
+		palette := palettes.Get(r.URL.Query().Get("palette"))
+		var hillshade *HillshadeParams
+		if hillshadeEnabled {
+			p := ParseHillshadeParams(r.URL.Query())
+			hillshade = &p
+		}
+		tile := renderer.RenderTile(coords, palette, hillshade)
 		if err := png.Encode(w, tile); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("internal server error: " + err.Error()))
@@ -71,78 +107,6 @@ func extractTileCoords(path string) (TileCoords, error) {
 	return coords, nil
 }
 
-func renderTile(coords TileCoords) image.Image {
-	const tileSize = 256
-	extent := tileExtent(coords)
-	tile := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
-	for y := 0; y < tileSize; y++ {
-		for x := 0; x < tileSize; x++ {
-			c := Vector{
-				extent.Min.X + (extent.Max.X-extent.Min.X)*float64(x)/tileSize,
-				extent.Min.Y + (extent.Max.X-extent.Min.X)*float64(y)/tileSize,
-			}
-			value := simplexTorus(c, coords)
-
-			// Draw the pixel.
-			colour := colouriseByValue(value)
-			tile.SetRGBA(x, y, colour)
-		}
-	}
-	return tile
-}
-
-func colouriseByValue(value float64) color.RGBA {
-var r, g, b float64
-	if value < -0.1 {
-		// Dark blue water
-		r = 0.0
-		g = 0.0
-		b = 0.4
-	} else if value < 0.2 {
-		// Blue water
-		maximum := 0.0
-		r = 0.1 + (maximum + value)
-		g = 0.1 + (maximum + value)
-		b = 0.5 + (maximum + value)
-	} else if value < 0.201 {
-		// Yellow sand
-		r = 500 * (0.202 - value)
-		g = 500 * (0.202 - value)
-		b = 250 * (0.202 - value)
-	} else if value < 0.40 {
-		// Grasslands
-		maximum := 0.40 + 0.20
-		r = 1.2 * (maximum - value)
-		g = 1.6 * (maximum - value)
-		b = 0.8 * (maximum - value)
-	} else if value < 0.60 {
-		// Greenery
-		maximum := 0.60 + 0.30
-		r = 0.2 * (maximum - value)
-		g = 0.8 * (maximum - value)
-		b = 0.1 * (maximum - value)
-	} else if value < 0.90 {
-		// Mountains
-		maximum := 0.90
-		minimum := 0.10
-		diff := maximum - minimum
-		r = 0.8 / diff * (value - minimum)
-		g = 0.7 / diff * (value - minimum)
-		b = 0.6 / diff * (value - minimum)
-	} else if value < 1.2 {
-		// Pale snow
-		r = 0.8 * value
-		g = 0.8 * value
-		b = 0.8 * value
-	} else {
-		// White snow
-		r = 1.0
-		g = 1.0
-		b = 1.0
-	}
-	return color.RGBA{uint8(r * 0xff), uint8(g * 0xff), uint8(b * 0xff), 0xff}
-}
-
 func colourise(value float64) color.RGBA {
 	value *= 215 // artistically chosen multiplier
 	return hslToRGB(math.Mod(value+360, 360), 0.5, 0.5)
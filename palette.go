@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PaletteStop is a single colour band boundary in a Palette. Values
+// from Threshold up to the next stop's threshold are blended from this
+// stop's colour toward the next one's according to Blend.
+type PaletteStop struct {
+	Threshold float64
+	Color     color.RGBA
+	Blend     string
+}
+
+// Palette is an ordered list of PaletteStops used to map a noise value
+// onto an RGBA colour. It replaces the hardcoded elevation bands that
+// used to live in colouriseByValue.
+type Palette struct {
+	Name  string
+	Stops []PaletteStop
+}
+
+// Load parses a palette file at path. Each non-empty, non-comment line
+// has the form "<threshold> <#rrggbb> <blend>", e.g.:
+//
+//	0.20 #ffe4a0 linear
+//
+// Blend is one of "flat", "linear" or "smoothstep" and describes how a
+// value between this stop and the next one is interpolated. Stops must
+// appear in ascending threshold order.
+func Load(path string) (*Palette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening palette %s: %v", path, err)
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	p := &Palette{Name: name}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("parsing palette %s: expected 3 fields, got %d: %q", path, len(fields), line)
+		}
+		threshold, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing threshold %q: %v", fields[0], err)
+		}
+		c, err := parseHexColor(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("parsing colour %q: %v", fields[1], err)
+		}
+		switch fields[2] {
+		case "flat", "linear", "smoothstep":
+		default:
+			return nil, fmt.Errorf("unknown blend mode %q", fields[2])
+		}
+		if len(p.Stops) > 0 && threshold < p.Stops[len(p.Stops)-1].Threshold {
+			return nil, fmt.Errorf("parsing palette %s: stops must be in ascending order, got %v after %v", path, threshold, p.Stops[len(p.Stops)-1].Threshold)
+		}
+		p.Stops = append(p.Stops, PaletteStop{threshold, c, fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading palette %s: %v", path, err)
+	}
+	if len(p.Stops) == 0 {
+		return nil, fmt.Errorf("palette %s has no stops", path)
+	}
+	return p, nil
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("expected 6 hex digits, got %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return color.RGBA{
+		R: uint8(v >> 16),
+		G: uint8(v >> 8),
+		B: uint8(v),
+		A: 0xff,
+	}, nil
+}
+
+// Colour maps value onto a colour by locating the stops either side of
+// it and blending between them. Values below the first stop or above
+// the last stop are clamped flat to that stop's colour.
+func (p *Palette) Colour(value float64) color.RGBA {
+	if value < p.Stops[0].Threshold {
+		return p.Stops[0].Color
+	}
+	for i := 1; i < len(p.Stops); i++ {
+		if value < p.Stops[i].Threshold {
+			return blendStops(p.Stops[i-1], p.Stops[i], value)
+		}
+	}
+	return p.Stops[len(p.Stops)-1].Color
+}
+
+// blendStops interpolates between from and to for a value that falls
+// between their thresholds, according to from.Blend.
+func blendStops(from, to PaletteStop, value float64) color.RGBA {
+	t := (value - from.Threshold) / (to.Threshold - from.Threshold)
+	switch from.Blend {
+	case "flat":
+		t = 0
+	case "smoothstep":
+		t = t * t * (3 - 2*t)
+	case "linear":
+		// t already linear
+	}
+	lerp := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t)
+	}
+	return color.RGBA{
+		R: lerp(from.Color.R, to.Color.R),
+		G: lerp(from.Color.G, to.Color.G),
+		B: lerp(from.Color.B, to.Color.B),
+		A: 0xff,
+	}
+}
+
+// PaletteSet holds every palette loaded via the -colors flag, selectable
+// per tile request by name via the ?palette= query string.
+type PaletteSet struct {
+	Default  string
+	Palettes map[string]*Palette
+}
+
+// LoadPaletteSet loads palettes from path. If path is a directory, every
+// "*.palette" file in it is loaded as a named palette (named after its
+// file stem); otherwise path is loaded as a single palette named
+// "default". The default palette is "default" if present, otherwise
+// whichever palette was loaded first.
+func LoadPaletteSet(path string) (*PaletteSet, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading palettes from %s: %v", path, err)
+	}
+
+	set := &PaletteSet{Palettes: map[string]*Palette{}}
+
+	if !info.IsDir() {
+		p, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		p.Name = "default"
+		set.Palettes["default"] = p
+		set.Default = "default"
+		return set, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(path, "*.palette"))
+	if err != nil {
+		return nil, fmt.Errorf("listing palettes in %s: %v", path, err)
+	}
+	for _, match := range matches {
+		p, err := Load(match)
+		if err != nil {
+			return nil, err
+		}
+		set.Palettes[p.Name] = p
+		if set.Default == "" {
+			set.Default = p.Name
+		}
+	}
+	if _, ok := set.Palettes["default"]; ok {
+		set.Default = "default"
+	}
+	if len(set.Palettes) == 0 {
+		return nil, fmt.Errorf("no *.palette files found in %s", path)
+	}
+	return set, nil
+}
+
+// Get returns the palette named name, or the set's default palette if
+// name is empty or unknown.
+func (s *PaletteSet) Get(name string) *Palette {
+	if p, ok := s.Palettes[name]; ok {
+		return p
+	}
+	return s.Palettes[s.Default]
+}
+
+// defaultPalette reproduces the elevation bands that colouriseByValue
+// used to hardcode, as the built-in palette used when -colors is not
+// set.
+func defaultPalette() *Palette {
+	return &Palette{
+		Name: "default",
+		Stops: []PaletteStop{
+			{-1.2, color.RGBA{0, 0, 102, 0xff}, "flat"},      // Dark blue water
+			{-0.1, color.RGBA{26, 26, 128, 0xff}, "linear"},  // Blue water
+			{0.2, color.RGBA{255, 255, 128, 0xff}, "flat"},   // Yellow sand
+			{0.201, color.RGBA{92, 150, 64, 0xff}, "linear"}, // Grasslands
+			{0.4, color.RGBA{26, 102, 13, 0xff}, "linear"},   // Greenery
+			{0.6, color.RGBA{143, 125, 107, 0xff}, "linear"}, // Mountains
+			{0.9, color.RGBA{204, 204, 204, 0xff}, "linear"}, // Pale snow
+			{1.2, color.RGBA{255, 255, 255, 0xff}, "flat"},   // White snow
+		},
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rowBand is the number of tile rows handed to a single worker as one
+// job, balancing job-queue overhead against how finely render work can
+// be spread across the pool.
+const rowBand = 16
+
+// Renderer owns a fixed pool of worker goroutines shared by every
+// rendering mode (noise tiles, buddhabrot, ...), plus a semaphore
+// bounding how many tile requests may be rendering at once, so a burst
+// of requests from a slippy-map client cannot spawn unbounded
+// goroutines.
+type Renderer struct {
+	jobs     chan func()
+	inFlight chan struct{}
+
+	latencyMu sync.Mutex
+	latencies []time.Duration
+}
+
+// latencyHistory is how many recent per-tile render latencies the
+// /metrics endpoint keeps around.
+const latencyHistory = 256
+
+// NewRenderer starts workers worker goroutines and allows at most
+// maxInFlight tile renders to run concurrently.
+func NewRenderer(workers, maxInFlight int) *Renderer {
+	r := &Renderer{
+		jobs:     make(chan func(), workers*4),
+		inFlight: make(chan struct{}, maxInFlight),
+	}
+	for i := 0; i < workers; i++ {
+		go r.worker()
+	}
+	return r
+}
+
+func (r *Renderer) worker() {
+	for job := range r.jobs {
+		job()
+	}
+}
+
+// RunAll submits each of jobs to the worker pool and blocks until every
+// one has completed.
+func (r *Renderer) RunAll(jobs []func()) {
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		job := job
+		wg.Add(1)
+		r.jobs <- func() {
+			defer wg.Done()
+			job()
+		}
+	}
+	wg.Wait()
+}
+
+// Acquire blocks until a render slot is free (at most maxInFlight
+// renders run concurrently) and returns a function that releases it.
+func (r *Renderer) Acquire() func() {
+	r.inFlight <- struct{}{}
+	return func() { <-r.inFlight }
+}
+
+// RenderTile renders coords into a 256x256 image using the renderer's
+// worker pool, blocking until a render slot is free if maxInFlight
+// renders are already in progress. If hillshade is non-nil, the palette
+// colour at each pixel is multiplied by a Lambertian shading factor
+// computed from the noise surface's gradient, which costs an extra
+// four simplexTorus evaluations per pixel.
+func (r *Renderer) RenderTile(coords TileCoords, palette *Palette, hillshade *HillshadeParams) image.Image {
+	release := r.Acquire()
+	defer release()
+
+	start := time.Now()
+	const tileSize = 256
+	extent := tileExtent(coords)
+	tile := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+
+	var jobs []func()
+	for y := 0; y < tileSize; y += rowBand {
+		y := y
+		end := y + rowBand
+		if end > tileSize {
+			end = tileSize
+		}
+		jobs = append(jobs, func() {
+			renderRows(tile, coords, palette, hillshade, extent, y, end)
+		})
+	}
+	r.RunAll(jobs)
+
+	r.recordLatency(time.Since(start))
+	return tile
+}
+
+// renderRows fills tile rows [rowStart, rowEnd) with noise sampled
+// across extent, coloured by palette and, if hillshade is non-nil,
+// shaded by it.
+func renderRows(tile *image.RGBA, coords TileCoords, palette *Palette, hillshade *HillshadeParams, extent Extent, rowStart, rowEnd int) {
+	const tileSize = 256
+	step := (extent.Max.X - extent.Min.X) / tileSize
+	for y := rowStart; y < rowEnd; y++ {
+		for x := 0; x < tileSize; x++ {
+			c := Vector{
+				extent.Min.X + (extent.Max.X-extent.Min.X)*float64(x)/tileSize,
+				extent.Min.Y + (extent.Max.Y-extent.Min.Y)*float64(y)/tileSize,
+			}
+			value := simplexTorus(c, coords)
+			colour := palette.Colour(value)
+			if hillshade != nil {
+				factor := hillshade.shade(surfaceNormalAt(c, coords, step))
+				colour.R = uint8(float64(colour.R) * factor)
+				colour.G = uint8(float64(colour.G) * factor)
+				colour.B = uint8(float64(colour.B) * factor)
+			}
+			tile.SetRGBA(x, y, colour)
+		}
+	}
+}
+
+func (r *Renderer) recordLatency(d time.Duration) {
+	r.latencyMu.Lock()
+	defer r.latencyMu.Unlock()
+	r.latencies = append(r.latencies, d)
+	if len(r.latencies) > latencyHistory {
+		r.latencies = r.latencies[len(r.latencies)-latencyHistory:]
+	}
+}
+
+// ServeHTTP reports recent per-tile render latencies in a minimal
+// Prometheus text-exposition format.
+func (r *Renderer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.latencyMu.Lock()
+	latencies := append([]time.Duration(nil), r.latencies...)
+	r.latencyMu.Unlock()
+
+	var sum, max time.Duration
+	for _, d := range latencies {
+		sum += d
+		if d > max {
+			max = d
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP simplexnoise_tile_render_seconds Per-tile render latency.\n")
+	fmt.Fprintf(w, "# TYPE simplexnoise_tile_render_seconds summary\n")
+	fmt.Fprintf(w, "simplexnoise_tile_render_seconds_count %d\n", len(latencies))
+	fmt.Fprintf(w, "simplexnoise_tile_render_seconds_sum %f\n", sum.Seconds())
+	fmt.Fprintf(w, "simplexnoise_tile_render_seconds_max %f\n", max.Seconds())
+}